@@ -6,12 +6,15 @@ import (
 	"encoding/hex"
 	"math/big"
 	"sync"
+	"time"
 
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	libp2pconfig "github.com/libp2p/go-libp2p/config"
+	"github.com/libp2p/go-libp2p/core/connmgr"
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/p2p/discovery/routing"
 	"github.com/libp2p/go-libp2p/p2p/discovery/util"
@@ -25,12 +28,41 @@ import (
 type BlossomSub struct {
 	ps         *blossomsub.PubSub
 	ctx        context.Context
+	cancel     context.CancelFunc
 	logger     *zap.Logger
 	peerID     peer.ID
+	bitmaskMx  sync.RWMutex
 	bitmaskMap map[string]*blossomsub.Bitmask
 	h          host.Host
+
+	handlersMx sync.RWMutex
+	handlers   map[string]func(message *pb.Message) error
+
+	identityKey      crypto.PrivKey
+	pubKeyLookup     func(peer.ID) crypto.PubKey
+	heartbeatMx      sync.Mutex
+	heartbeatCounter uint64
+	peerInventory    map[peer.ID]*PeerInventoryEntry
+
+	peerstore      *persistentPeerstore
+	reconnectMx    sync.Mutex
+	reconnectDelay map[peer.ID]*reconnectState
+
+	connManager  connmgr.ConnManager
+	pendingDials int64
+
+	validationQueueSize int
+	processingWorkers   int
+
+	reachabilityMx sync.Mutex
+	reachability   network.Reachability
 }
 
+// scoreInspectInterval controls how often peer scores are logged by
+// newScoreDumper when peer scoring is enabled. The scores are not written
+// to the tracer.
+const scoreInspectInterval = 10 * time.Second
+
 var _ PubSub = (*BlossomSub)(nil)
 var ErrNoPeersAvailable = errors.New("no peers available")
 
@@ -47,12 +79,47 @@ func NewBlossomSub(
 	p2pConfig *config.P2PConfig,
 	logger *zap.Logger,
 ) *BlossomSub {
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
 
 	opts := []libp2pconfig.Option{
 		libp2p.ListenAddrStrings(p2pConfig.ListenMultiaddr),
 	}
 
+	natOpts, err := transportOpts(p2pConfig, logger)
+	if err != nil {
+		panic(errors.Wrap(err, "error building transport options"))
+	}
+	opts = append(opts, natOpts...)
+
+	var connManager connmgr.ConnManager
+	var gater *subnetGater
+	if p2pConfig.ConnMgr != nil {
+		var err error
+		connManager, gater, err = newConnManager(p2pConfig.ConnMgr)
+		if err != nil {
+			panic(errors.Wrap(err, "error constructing connection manager"))
+		}
+
+		for _, addr := range p2pConfig.BootstrapPeers {
+			info, err := peer.AddrInfoFromString(addr)
+			if err != nil {
+				continue
+			}
+			gater.protect(info.ID)
+			connManager.Protect(info.ID, "bootstrap")
+		}
+		for _, addr := range p2pConfig.DirectPeers {
+			info, err := peer.AddrInfoFromString(addr)
+			if err != nil {
+				continue
+			}
+			gater.protect(info.ID)
+			connManager.Protect(info.ID, "direct")
+		}
+
+		opts = append(opts, libp2p.ConnectionManager(connManager), libp2p.ConnectionGater(gater))
+	}
+
 	if p2pConfig.PeerPrivKey != "" {
 		peerPrivKey, err := hex.DecodeString(p2pConfig.PeerPrivKey)
 		if err != nil {
@@ -74,6 +141,20 @@ func NewBlossomSub(
 
 	logger.Info("established peer id", zap.String("peer_id", h.ID().String()))
 
+	if gater != nil {
+		h.Network().Notify(gater)
+	}
+
+	var peerstore *persistentPeerstore
+	if p2pConfig.PeerstorePath != "" {
+		peerstore, err = newPersistentPeerstore(p2pConfig.PeerstorePath, logger)
+		if err != nil {
+			panic(errors.Wrap(err, "error opening persistent peerstore"))
+		}
+
+		h.Network().Notify(&peerstoreNotifiee{ps: peerstore, h: h})
+	}
+
 	go discoverPeers(p2pConfig, ctx, logger, h)
 
 	var tracer *blossomsub.JSONTracer
@@ -93,6 +174,22 @@ func NewBlossomSub(
 		blossomsub.WithEventTracer(tracer),
 	}
 
+	if p2pConfig.PeerScoreParams != nil {
+		thresholds := p2pConfig.PeerScoreThresholds
+		if thresholds == nil {
+			thresholds = &blossomsub.PeerScoreThresholds{}
+		}
+
+		blossomOpts = append(
+			blossomOpts,
+			blossomsub.WithPeerScore(p2pConfig.PeerScoreParams, thresholds),
+			blossomsub.WithPeerScoreInspect(
+				newScoreDumper(logger),
+				scoreInspectInterval,
+			),
+		)
+	}
+
 	params := mergeDefaults(p2pConfig)
 	rt := blossomsub.NewBlossomSubRouter(h, params)
 	ps, err := blossomsub.NewBlossomSubWithRouter(ctx, h, rt, blossomOpts...)
@@ -102,18 +199,69 @@ func NewBlossomSub(
 
 	peerID := h.ID()
 
-	return &BlossomSub{
-		ps,
-		ctx,
-		logger,
-		peerID,
-		make(map[string]*blossomsub.Bitmask),
-		h,
+	queueSize := p2pConfig.ValidationQueueSize
+	if queueSize == 0 {
+		queueSize = defaultValidationQueueSize
+	}
+
+	workers := p2pConfig.ProcessingWorkers
+	if workers == 0 {
+		workers = defaultValidationWorkers
+	}
+
+	b := &BlossomSub{
+		ps:                  ps,
+		ctx:                 ctx,
+		cancel:              cancel,
+		logger:              logger,
+		peerID:              peerID,
+		bitmaskMap:          make(map[string]*blossomsub.Bitmask),
+		h:                   h,
+		handlers:            make(map[string]func(message *pb.Message) error),
+		identityKey:         h.Peerstore().PrivKey(peerID),
+		pubKeyLookup:        h.Peerstore().PubKey,
+		peerInventory:       make(map[peer.ID]*PeerInventoryEntry),
+		peerstore:           peerstore,
+		reconnectDelay:      make(map[peer.ID]*reconnectState),
+		connManager:         connManager,
+		validationQueueSize: queueSize,
+		processingWorkers:   workers,
+		reachability:        network.ReachabilityUnknown,
+	}
+
+	if peerstore != nil {
+		go b.runReconnector(ctx, h)
+	}
+
+	if connManager != nil {
+		go b.watchConnCounts(ctx)
 	}
+
+	go b.watchReachability(ctx, h)
+
+	if p2pConfig.PeerHeartbeatInterval != 0 {
+		window := p2pConfig.HeartbeatWindow
+		if window == 0 {
+			window = defaultHeartbeatWindow
+		}
+
+		b.Subscribe(BITMASK_HEARTBEAT, b.handleHeartbeat(window), true)
+		go b.runHeartbeat(
+			ctx,
+			p2pConfig.PeerHeartbeatInterval,
+			p2pConfig.NodeName,
+			p2pConfig.Version,
+			p2pConfig.HeartbeatFeatures,
+		)
+	}
+
+	return b
 }
 
 func (b *BlossomSub) PublishToBitmask(bitmask []byte, data []byte) error {
+	b.bitmaskMx.RLock()
 	bm, ok := b.bitmaskMap[string(bitmask)]
+	b.bitmaskMx.RUnlock()
 	if !ok {
 		b.logger.Error(
 			"error while publishing to bitmask",
@@ -131,13 +279,48 @@ func (b *BlossomSub) Publish(data []byte) error {
 	return b.PublishToBitmask(bitmask, data)
 }
 
+// RegisterHandler pre-registers handler as the asynchronous processing
+// callback for bitmask, so a later call to Subscribe(bitmask, nil, raw) can
+// join without repeating it. handler runs off a bounded per-bitmask queue
+// drained by a pool of workers, so a slow handler cannot stall the receive
+// path; use RegisterValidator instead for synchronous accept/reject/ignore
+// decisions that feed peer scoring.
+func (b *BlossomSub) RegisterHandler(
+	bitmask []byte,
+	handler func(message *pb.Message) error,
+) {
+	b.handlersMx.Lock()
+	defer b.handlersMx.Unlock()
+	b.handlers[string(bitmask)] = handler
+}
+
+// Subscribe joins bitmask and streams it into handler. If handler is nil,
+// the callback previously passed to RegisterHandler for the same bitmask is
+// used instead.
 func (b *BlossomSub) Subscribe(
 	bitmask []byte,
 	handler func(message *pb.Message) error,
 	raw bool,
 ) {
+	if handler != nil {
+		b.RegisterHandler(bitmask, handler)
+	}
+
+	b.handlersMx.RLock()
+	handler, ok := b.handlers[string(bitmask)]
+	b.handlersMx.RUnlock()
+	if !ok {
+		b.logger.Error(
+			"subscribe called with no registered handler",
+			zap.Binary("bitmask", bitmask),
+		)
+		return
+	}
+
 	eval := func(bitmask []byte) error {
+		b.bitmaskMx.RLock()
 		_, ok := b.bitmaskMap[string(bitmask)]
+		b.bitmaskMx.RUnlock()
 		if ok {
 			return nil
 		}
@@ -149,7 +332,9 @@ func (b *BlossomSub) Subscribe(
 			return errors.Wrap(err, "subscribe")
 		}
 
+		b.bitmaskMx.Lock()
 		b.bitmaskMap[string(bitmask)] = bm
+		b.bitmaskMx.Unlock()
 
 		b.logger.Info("subscribe to bitmask", zap.Binary("bitmask", bitmask))
 		sub, err := bm.Subscribe()
@@ -162,18 +347,35 @@ func (b *BlossomSub) Subscribe(
 			"begin streaming from bitmask",
 			zap.Binary("bitmask", bitmask),
 		)
+
+		queue := b.startValidationQueue(bitmask, handler)
+
 		go func() {
+			defer close(queue)
+
 			for {
 				m, err := sub.Next(b.ctx)
 				if err != nil {
+					// sub.Next only errors once the subscription is
+					// cancelled or its context is done; either way there is
+					// nothing left to receive, so this is terminal, not a
+					// transient condition to retry.
 					b.logger.Error(
-						"got error when fetching the next message",
+						"subscription closed, stopping receive loop",
+						zap.Binary("bitmask", bitmask),
 						zap.Error(err),
 					)
+					return
 				}
 
-				if err = handler(m.Message); err != nil {
-					b.logger.Error("message handler returned error", zap.Error(err))
+				select {
+				case queue <- m.Message:
+				default:
+					validationDropped.WithLabelValues(string(bitmask)).Inc()
+					b.logger.Warn(
+						"validation queue full, dropping message",
+						zap.Binary("bitmask", bitmask),
+					)
 				}
 			}
 		}()
@@ -193,7 +395,9 @@ func (b *BlossomSub) Subscribe(
 }
 
 func (b *BlossomSub) Unsubscribe(bitmask []byte, raw bool) {
+	b.bitmaskMx.RLock()
 	bm, ok := b.bitmaskMap[string(bitmask)]
+	b.bitmaskMx.RUnlock()
 	if !ok {
 		return
 	}
@@ -201,6 +405,48 @@ func (b *BlossomSub) Unsubscribe(bitmask []byte, raw bool) {
 	bm.Close()
 }
 
+// newScoreDumper returns a WithPeerScoreInspect callback that logs a
+// snapshot of every peer's score through logger. blossomsub.TraceEvent has
+// no case for a score snapshot, so this can't be folded into the same
+// tracer the mesh prune/graft events go to; operators correlate the two by
+// timestamp and peer ID instead.
+func newScoreDumper(
+	logger *zap.Logger,
+) func(scores map[peer.ID]*blossomsub.PeerScoreSnapshot) {
+	return func(scores map[peer.ID]*blossomsub.PeerScoreSnapshot) {
+		for p, snapshot := range scores {
+			logger.Debug(
+				"peer score snapshot",
+				zap.String("peer_id", p.String()),
+				zap.Float64("score", snapshot.Score),
+				zap.Float64("app_specific_score", snapshot.AppSpecificScore),
+				zap.Float64("behaviour_penalty", snapshot.BehaviourPenalty),
+				zap.Float64("ip_colocation_factor", snapshot.IPColocationFactor),
+			)
+		}
+	}
+}
+
+// RegisterValidator installs a synchronous validator for bitmask. Validator
+// results feed directly into the peer-score behaviour-penalty pipeline, so
+// it should return quickly; slow, asynchronous work belongs in the handler
+// passed to Subscribe instead.
+func (b *BlossomSub) RegisterValidator(
+	bitmask []byte,
+	validator func(
+		ctx context.Context,
+		from peer.ID,
+		msg *pb.Message,
+	) blossomsub.ValidationResult,
+) error {
+	return b.ps.RegisterTopicValidator(
+		bitmask,
+		func(ctx context.Context, from peer.ID, msg *blossomsub.Message) blossomsub.ValidationResult {
+			return validator(ctx, from, msg.Message)
+		},
+	)
+}
+
 func (b *BlossomSub) GetPeerID() []byte {
 	return []byte(b.peerID)
 }
@@ -268,6 +514,19 @@ func (b *BlossomSub) GetNetworkPeersCount() int {
 	return len(b.h.Network().Peers())
 }
 
+// Close cancels b's lifecycle context, stopping runHeartbeat,
+// watchConnCounts, runReconnector, watchReachability and every bitmask's
+// receive loop, then closes the persistent peerstore if one is open.
+func (b *BlossomSub) Close() error {
+	b.cancel()
+
+	if b.peerstore != nil {
+		return b.peerstore.Close()
+	}
+
+	return nil
+}
+
 func discoverPeers(
 	p2pConfig *config.P2PConfig,
 	ctx context.Context,
@@ -0,0 +1,295 @@
+package p2p
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/control"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	gocmgr "github.com/libp2p/go-libp2p/p2p/net/connmgr"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// ConnMgrConfig bounds the size of the connection set libp2p keeps open
+// and caps how many of those connections may land in the same IPv4 /24 or
+// IPv6 /64 so one colocated group of peers cannot dominate the mesh.
+type ConnMgrConfig struct {
+	LowWater               int
+	HighWater              int
+	GracePeriod            time.Duration
+	MaxPerIPv4Subnet       int
+	MaxPerIPv6Subnet       int
+	SubnetColocationWeight float64
+}
+
+var (
+	connsOpen = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "p2p_connections_open",
+		Help: "Number of open libp2p connections.",
+	})
+	connsPending = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "p2p_connections_pending",
+		Help: "Number of reconnector dials to persisted peers in progress; other dials are not counted.",
+	})
+	connsTrimmed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "p2p_connections_trimmed_total",
+		Help: "Count of connections closed by the connection manager's trim pass.",
+	})
+)
+
+// subnetColocationTag is the connmgr tag subnetGater upserts per peer to
+// reflect how crowded that peer's subnet is, so TrimOpenConns prefers
+// trimming colocated peers over a subnet's sole connection.
+const subnetColocationTag = "subnet-colocation"
+
+// subnetGater is a connmgr.ConnectionGater that caps how many simultaneous
+// connections may come from the same IPv4 /24 or IPv6 /64, while never
+// rejecting a protected peer.
+type subnetGater struct {
+	mx               sync.Mutex
+	perSubnet        map[string]int
+	subnetPeers      map[string]map[peer.ID]struct{}
+	maxPerIPv4Subnet int
+	maxPerIPv6Subnet int
+	protected        map[peer.ID]struct{}
+	connManager      connmgr.ConnManager
+	colocationWeight float64
+}
+
+func newSubnetGater(maxV4, maxV6 int, colocationWeight float64, cm connmgr.ConnManager) *subnetGater {
+	return &subnetGater{
+		perSubnet:        make(map[string]int),
+		subnetPeers:      make(map[string]map[peer.ID]struct{}),
+		maxPerIPv4Subnet: maxV4,
+		maxPerIPv6Subnet: maxV6,
+		protected:        make(map[peer.ID]struct{}),
+		connManager:      cm,
+		colocationWeight: colocationWeight,
+	}
+}
+
+func (g *subnetGater) protect(id peer.ID) {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	g.protected[id] = struct{}{}
+}
+
+// subnetKey returns the /24 (IPv4) or /64 (IPv6) network a's IP falls in,
+// along with whether that network is IPv6, so callers never have to infer
+// address family from the formatted key.
+func subnetKey(a multiaddr.Multiaddr) (key string, isV6 bool, ok bool) {
+	ip, err := manet.ToIP(a)
+	if err != nil {
+		return "", false, false
+	}
+
+	if v4 := ip.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(24, 32)), Mask: net.CIDRMask(24, 32)}).String(), false, true
+	}
+
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(64, 128)), Mask: net.CIDRMask(64, 128)}).String(), true, true
+}
+
+func (g *subnetGater) allow(id peer.ID, a multiaddr.Multiaddr) bool {
+	g.mx.Lock()
+	defer g.mx.Unlock()
+
+	if _, ok := g.protected[id]; ok {
+		return true
+	}
+
+	key, isV6, ok := subnetKey(a)
+	if !ok {
+		return true
+	}
+
+	limit := g.maxPerIPv4Subnet
+	if isV6 {
+		limit = g.maxPerIPv6Subnet
+	}
+
+	if limit <= 0 {
+		return true
+	}
+
+	return g.perSubnet[key] < limit
+}
+
+func (g *subnetGater) track(a multiaddr.Multiaddr, delta int) {
+	key, _, ok := subnetKey(a)
+	if !ok {
+		return
+	}
+
+	g.mx.Lock()
+	defer g.mx.Unlock()
+	g.perSubnet[key] += delta
+	if g.perSubnet[key] <= 0 {
+		delete(g.perSubnet, key)
+	}
+}
+
+// tagColocation, when a connManager and non-zero colocationWeight are
+// configured, keeps subnetPeers' membership for a's subnet in sync and
+// upserts every member's subnetColocationTag to -colocationWeight*(other
+// peers sharing the subnet); otherwise it does nothing. BasicConnMgr trims
+// the lowest-tagged peers first, so a subnet with several connections
+// becomes a preferred trim target while a subnet's sole connection carries
+// a tag of 0.
+func (g *subnetGater) tagColocation(id peer.ID, a multiaddr.Multiaddr, delta int) {
+	if g.connManager == nil || g.colocationWeight == 0 {
+		return
+	}
+
+	key, _, ok := subnetKey(a)
+	if !ok {
+		return
+	}
+
+	g.mx.Lock()
+	peers, ok := g.subnetPeers[key]
+	if !ok {
+		peers = make(map[peer.ID]struct{})
+		g.subnetPeers[key] = peers
+	}
+	if delta > 0 {
+		peers[id] = struct{}{}
+	} else {
+		delete(peers, id)
+		if len(peers) == 0 {
+			delete(g.subnetPeers, key)
+		}
+	}
+	members := make([]peer.ID, 0, len(peers))
+	for p := range peers {
+		members = append(members, p)
+	}
+	g.mx.Unlock()
+
+	penalty := -int(g.colocationWeight * float64(len(members)-1))
+	for _, p := range members {
+		g.connManager.UpsertTag(p, subnetColocationTag, func(int) int { return penalty })
+	}
+}
+
+// Disconnected implements network.Notifiee so a subnet's count is released
+// once its connection actually closes, not just incremented on accept.
+func (g *subnetGater) Disconnected(_ network.Network, conn network.Conn) {
+	g.track(conn.RemoteMultiaddr(), -1)
+	g.tagColocation(conn.RemotePeer(), conn.RemoteMultiaddr(), -1)
+}
+
+func (g *subnetGater) Listen(network.Network, multiaddr.Multiaddr)      {}
+func (g *subnetGater) ListenClose(network.Network, multiaddr.Multiaddr) {}
+func (g *subnetGater) Connected(network.Network, network.Conn)         {}
+
+var _ network.Notifiee = (*subnetGater)(nil)
+
+func (g *subnetGater) InterceptPeerDial(peer.ID) bool { return true }
+
+func (g *subnetGater) InterceptAddrDial(id peer.ID, a multiaddr.Multiaddr) bool {
+	return g.allow(id, a)
+}
+
+// InterceptAccept admits every inbound connection: the remote peer ID is not
+// known yet, so the subnet limit is enforced in InterceptSecured, where a
+// protected peer can be told apart.
+func (g *subnetGater) InterceptAccept(network.ConnMultiaddrs) bool {
+	return true
+}
+
+func (g *subnetGater) InterceptSecured(
+	dir network.Direction,
+	id peer.ID,
+	addrs network.ConnMultiaddrs,
+) bool {
+	if !g.allow(id, addrs.RemoteMultiaddr()) {
+		return false
+	}
+
+	g.track(addrs.RemoteMultiaddr(), 1)
+	g.tagColocation(id, addrs.RemoteMultiaddr(), 1)
+	return true
+}
+
+func (g *subnetGater) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+var _ connmgr.ConnectionGater = (*subnetGater)(nil)
+
+// newConnManager builds the libp2p ConnectionManager and companion subnet
+// gater described by cfg.
+func newConnManager(cfg *ConnMgrConfig) (connmgr.ConnManager, *subnetGater, error) {
+	cm, err := gocmgr.NewConnManager(
+		cfg.LowWater,
+		cfg.HighWater,
+		gocmgr.WithGracePeriod(cfg.GracePeriod),
+	)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "build connection manager")
+	}
+
+	gater := newSubnetGater(cfg.MaxPerIPv4Subnet, cfg.MaxPerIPv6Subnet, cfg.SubnetColocationWeight, cm)
+
+	return cm, gater, nil
+}
+
+// connCountInterval is how often watchConnCounts refreshes the open/pending
+// connection gauges.
+const connCountInterval = 15 * time.Second
+
+// watchConnCounts keeps the open/pending connection gauges up to date
+// while the node runs.
+func (b *BlossomSub) watchConnCounts(ctx context.Context) {
+	ticker := time.NewTicker(connCountInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			connsOpen.Set(float64(len(b.h.Network().Conns())))
+			connsPending.Set(float64(atomic.LoadInt64(&b.pendingDials)))
+		}
+	}
+}
+
+// dialTracked wraps host.Connect, tracking the attempt in the
+// p2p_connections_pending gauge for the duration of the dial.
+func (b *BlossomSub) dialTracked(
+	ctx context.Context,
+	h host.Host,
+	info peer.AddrInfo,
+) error {
+	atomic.AddInt64(&b.pendingDials, 1)
+	defer atomic.AddInt64(&b.pendingDials, -1)
+
+	return h.Connect(ctx, info)
+}
+
+// TrimOpenConns asks the connection manager to trim idle connections down
+// to the low watermark.
+func (b *BlossomSub) TrimOpenConns(ctx context.Context) {
+	if b.connManager == nil {
+		return
+	}
+
+	before := len(b.h.Network().Conns())
+	b.connManager.TrimOpenConns(ctx)
+
+	if trimmed := before - len(b.h.Network().Conns()); trimmed > 0 {
+		connsTrimmed.Add(float64(trimmed))
+	}
+}
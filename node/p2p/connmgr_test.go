@@ -0,0 +1,176 @@
+package p2p
+
+import (
+	"context"
+	"testing"
+
+	"github.com/libp2p/go-libp2p/core/connmgr"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// fakeConnManager records UpsertTag calls so tests can assert on
+// subnetGater's colocation-weight wiring without a real BasicConnMgr.
+type fakeConnManager struct {
+	tags map[peer.ID]int
+}
+
+func newFakeConnManager() *fakeConnManager {
+	return &fakeConnManager{tags: make(map[peer.ID]int)}
+}
+
+func (f *fakeConnManager) TagPeer(p peer.ID, _ string, value int)  { f.tags[p] = value }
+func (f *fakeConnManager) UntagPeer(p peer.ID, _ string)           { delete(f.tags, p) }
+func (f *fakeConnManager) UpsertTag(p peer.ID, _ string, upsert func(int) int) {
+	f.tags[p] = upsert(f.tags[p])
+}
+func (f *fakeConnManager) GetTagInfo(peer.ID) *connmgr.TagInfo  { return nil }
+func (f *fakeConnManager) TrimOpenConns(context.Context)        {}
+func (f *fakeConnManager) Notifee() network.Notifiee            { return nil }
+func (f *fakeConnManager) Protect(peer.ID, string)               {}
+func (f *fakeConnManager) Unprotect(peer.ID, string) bool        { return false }
+func (f *fakeConnManager) IsProtected(peer.ID, string) bool      { return false }
+func (f *fakeConnManager) Close() error                          { return nil }
+
+var _ connmgr.ConnManager = (*fakeConnManager)(nil)
+
+func mustMultiaddr(t *testing.T, s string) multiaddr.Multiaddr {
+	t.Helper()
+
+	a, err := multiaddr.NewMultiaddr(s)
+	if err != nil {
+		t.Fatalf("parse multiaddr %q: %v", s, err)
+	}
+
+	return a
+}
+
+func TestSubnetKey(t *testing.T) {
+	v4a := mustMultiaddr(t, "/ip4/10.1.2.3/tcp/1000")
+	v4b := mustMultiaddr(t, "/ip4/10.1.2.200/tcp/2000")
+	v4c := mustMultiaddr(t, "/ip4/10.1.3.3/tcp/1000")
+	v6 := mustMultiaddr(t, "/ip6/2001:db8::1/tcp/1000")
+
+	keyA, isV6A, okA := subnetKey(v4a)
+	keyB, isV6B, okB := subnetKey(v4b)
+	keyC, _, okC := subnetKey(v4c)
+
+	if !okA || !okB || !okC {
+		t.Fatalf("expected subnetKey to succeed for IPv4 addresses")
+	}
+	if isV6A || isV6B {
+		t.Fatalf("expected IPv4 addresses to report isV6=false")
+	}
+	if keyA != keyB {
+		t.Errorf("expected %q and %q to share a /24, got %q and %q", v4a, v4b, keyA, keyB)
+	}
+	if keyA == keyC {
+		t.Errorf("expected %q and %q to fall in different /24s, both got %q", v4a, v4c, keyA)
+	}
+
+	keyV6, isV6, okV6 := subnetKey(v6)
+	if !okV6 {
+		t.Fatalf("expected subnetKey to succeed for an IPv6 address")
+	}
+	if !isV6 {
+		t.Errorf("expected IPv6 address to report isV6=true")
+	}
+	if keyV6 == keyA {
+		t.Errorf("expected IPv4 and IPv6 subnet keys to differ")
+	}
+}
+
+func TestSubnetGaterAllowEnforcesLimit(t *testing.T) {
+	g := newSubnetGater(2, 2, 0, nil)
+
+	same := mustMultiaddr(t, "/ip4/10.1.2.3/tcp/1000")
+	other := mustMultiaddr(t, "/ip4/10.1.2.200/tcp/2000")
+
+	if !g.allow("", same) {
+		t.Fatalf("expected first connection from a subnet to be allowed")
+	}
+	g.track(same, 1)
+
+	if !g.allow("", other) {
+		t.Fatalf("expected second connection from the same subnet to be allowed")
+	}
+	g.track(other, 1)
+
+	third := mustMultiaddr(t, "/ip4/10.1.2.201/tcp/3000")
+	if g.allow("", third) {
+		t.Fatalf("expected third connection from the same /24 to be rejected")
+	}
+}
+
+func TestSubnetGaterAllowsProtectedPeerOverLimit(t *testing.T) {
+	g := newSubnetGater(1, 1, 0, nil)
+
+	addr := mustMultiaddr(t, "/ip4/10.1.2.3/tcp/1000")
+	g.track(addr, 1)
+
+	protected := peer.ID("protected-peer")
+	g.protect(protected)
+
+	if !g.allow(protected, addr) {
+		t.Fatalf("expected a protected peer to be allowed despite the subnet being full")
+	}
+}
+
+func TestSubnetGaterTrackReleasesOnDisconnect(t *testing.T) {
+	g := newSubnetGater(1, 1, 0, nil)
+
+	addr := mustMultiaddr(t, "/ip4/10.1.2.3/tcp/1000")
+	g.track(addr, 1)
+
+	other := mustMultiaddr(t, "/ip4/10.1.2.200/tcp/2000")
+	if g.allow("", other) {
+		t.Fatalf("expected the subnet to be full before the connection is released")
+	}
+
+	// Disconnected releases the slot through track(-1) (its tagColocation
+	// call is a no-op here without a connManager); exercise that path
+	// directly since constructing a full network.Conn fake isn't needed to
+	// verify the counting behavior it relies on.
+	g.track(addr, -1)
+
+	if !g.allow("", other) {
+		t.Fatalf("expected the subnet slot to be released once the connection was torn down")
+	}
+}
+
+func TestSubnetGaterTagsColocatedPeers(t *testing.T) {
+	cm := newFakeConnManager()
+	g := newSubnetGater(10, 10, 1.0, cm)
+
+	addrA := mustMultiaddr(t, "/ip4/10.1.2.3/tcp/1000")
+	addrB := mustMultiaddr(t, "/ip4/10.1.2.200/tcp/2000")
+	peerA := peer.ID("peer-a")
+	peerB := peer.ID("peer-b")
+
+	g.tagColocation(peerA, addrA, 1)
+	if cm.tags[peerA] != 0 {
+		t.Fatalf("expected a lone peer in its subnet to carry no colocation penalty, got %d", cm.tags[peerA])
+	}
+
+	g.tagColocation(peerB, addrB, 1)
+	if cm.tags[peerA] != -1 || cm.tags[peerB] != -1 {
+		t.Fatalf(
+			"expected both peers sharing a /24 to be tagged with -weight*(peers-1), got peerA=%d peerB=%d",
+			cm.tags[peerA], cm.tags[peerB],
+		)
+	}
+
+	g.tagColocation(peerB, addrB, -1)
+	if cm.tags[peerA] != 0 {
+		t.Fatalf("expected the remaining peer's penalty to clear once its subnet-mate disconnected, got %d", cm.tags[peerA])
+	}
+}
+
+func TestSubnetGaterTagColocationNoopWithoutConnManager(t *testing.T) {
+	g := newSubnetGater(10, 10, 1.0, nil)
+	addr := mustMultiaddr(t, "/ip4/10.1.2.3/tcp/1000")
+
+	// Must not panic when no connManager is configured.
+	g.tagColocation(peer.ID("peer-a"), addr, 1)
+}
@@ -0,0 +1,231 @@
+package p2p
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"go.uber.org/zap"
+	"source.quilibrium.com/quilibrium/monorepo/go-libp2p-blossomsub/pb"
+)
+
+// BITMASK_HEARTBEAT is the well-known bitmask signed heartbeats are
+// broadcast on, separate from application traffic bitmasks.
+var BITMASK_HEARTBEAT = []byte{
+	0x68, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74,
+}
+
+// defaultHeartbeatWindow bounds how stale a heartbeat may be before it is
+// rejected as a replay.
+const defaultHeartbeatWindow = 5 * time.Minute
+
+// defaultHeartbeatInventoryTTL bounds how long a peer's inventory entry is
+// trusted for the monotonic-counter replay check. A sender's counter resets
+// to zero on restart, so once an entry is older than this the counter check
+// is dropped in favor of the timestamp window alone, otherwise a restarted
+// peer would be rejected forever by its own last (higher) counter.
+const defaultHeartbeatInventoryTTL = 2 * time.Hour
+
+// Heartbeat is the signed, periodic peer-inventory announcement every node
+// broadcasts on BITMASK_HEARTBEAT so the rest of the mesh can build an
+// authenticated picture of who is online and what they support.
+type Heartbeat struct {
+	NodeName             string   `json:"node_name"`
+	Version              string   `json:"version"`
+	Timestamp            int64    `json:"timestamp"`
+	Features             []string `json:"features"`
+	BitmaskSubscriptions [][]byte `json:"bitmask_subscriptions"`
+	Counter              uint64   `json:"counter"`
+	Nonce                []byte   `json:"nonce"`
+	Signature            []byte   `json:"signature"`
+}
+
+// signingPayload returns the canonical bytes a Heartbeat's Signature is
+// computed over.
+func (h *Heartbeat) signingPayload() ([]byte, error) {
+	unsigned := *h
+	unsigned.Signature = nil
+	return json.Marshal(unsigned)
+}
+
+// PeerInventoryEntry is the last-known-good state recorded for a peer seen
+// via the heartbeat channel.
+type PeerInventoryEntry struct {
+	Heartbeat *Heartbeat
+	LastSeen  time.Time
+}
+
+var (
+	heartbeatsReceived = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "p2p_heartbeats_received_total",
+			Help: "Count of valid signed heartbeats received, by sender version.",
+		},
+		[]string{"version"},
+	)
+	heartbeatPeerCount = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "p2p_peer_count",
+			Help: "Number of peers with an entry in the heartbeat inventory; entries are never removed.",
+		},
+	)
+)
+
+// runHeartbeat periodically publishes a signed Heartbeat on
+// BITMASK_HEARTBEAT. It is started from NewBlossomSub whenever
+// PeerHeartbeatInterval is non-zero and runs until ctx is done.
+func (b *BlossomSub) runHeartbeat(
+	ctx context.Context,
+	interval time.Duration,
+	nodeName string,
+	version string,
+	features []string,
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := b.publishHeartbeat(nodeName, version, features); err != nil {
+				b.logger.Warn("failed to publish heartbeat", zap.Error(err))
+			}
+		}
+	}
+}
+
+func (b *BlossomSub) publishHeartbeat(
+	nodeName string,
+	version string,
+	features []string,
+) error {
+	b.heartbeatMx.Lock()
+	b.heartbeatCounter++
+	counter := b.heartbeatCounter
+	b.heartbeatMx.Unlock()
+
+	b.bitmaskMx.RLock()
+	subs := make([][]byte, 0, len(b.bitmaskMap))
+	for bm := range b.bitmaskMap {
+		subs = append(subs, []byte(bm))
+	}
+	b.bitmaskMx.RUnlock()
+
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return errors.Wrap(err, "publish heartbeat")
+	}
+
+	hb := &Heartbeat{
+		NodeName:             nodeName,
+		Version:              version,
+		Timestamp:            time.Now().UnixMilli(),
+		Features:             features,
+		BitmaskSubscriptions: subs,
+		Counter:              counter,
+		Nonce:                nonce,
+	}
+
+	payload, err := hb.signingPayload()
+	if err != nil {
+		return errors.Wrap(err, "publish heartbeat")
+	}
+
+	sig, err := b.identityKey.Sign(payload)
+	if err != nil {
+		return errors.Wrap(err, "publish heartbeat")
+	}
+	hb.Signature = sig
+
+	data, err := json.Marshal(hb)
+	if err != nil {
+		return errors.Wrap(err, "publish heartbeat")
+	}
+
+	return b.PublishToBitmask(BITMASK_HEARTBEAT, data)
+}
+
+// handleHeartbeat verifies and records an incoming heartbeat, rejecting
+// replays and bad signatures. It is installed as the handler for
+// BITMASK_HEARTBEAT from NewBlossomSub.
+func (b *BlossomSub) handleHeartbeat(
+	window time.Duration,
+) func(m *pb.Message) error {
+	return func(m *pb.Message) error {
+		from, err := peer.IDFromBytes(m.From)
+		if err != nil {
+			return errors.Wrap(err, "handle heartbeat")
+		}
+
+		var hb Heartbeat
+		if err := json.Unmarshal(m.Data, &hb); err != nil {
+			return errors.Wrap(err, "handle heartbeat")
+		}
+
+		age := time.Since(time.UnixMilli(hb.Timestamp))
+		if age < 0 || age > window {
+			return errors.New("heartbeat outside of acceptance window")
+		}
+
+		// from.ExtractPublicKey only works for peer IDs small enough to embed
+		// the key inline; this network's Ed448 identity keys are not, so the
+		// key has to come from whatever already recorded it for from, which
+		// libp2p's security handshake does on every connection.
+		pub := b.pubKeyLookup(from)
+		if pub == nil {
+			return errors.New("handle heartbeat: no known public key for peer")
+		}
+
+		payload, err := hb.signingPayload()
+		if err != nil {
+			return errors.Wrap(err, "handle heartbeat")
+		}
+
+		ok, err := pub.Verify(payload, hb.Signature)
+		if err != nil || !ok {
+			return errors.New("heartbeat signature verification failed")
+		}
+
+		b.heartbeatMx.Lock()
+		prior, known := b.peerInventory[from]
+		if known && time.Since(prior.LastSeen) > defaultHeartbeatInventoryTTL {
+			known = false
+		}
+		if known && hb.Counter <= prior.Heartbeat.Counter {
+			b.heartbeatMx.Unlock()
+			return errors.New("non-monotonic heartbeat counter, possible replay")
+		}
+
+		b.peerInventory[from] = &PeerInventoryEntry{
+			Heartbeat: &hb,
+			LastSeen:  time.Now(),
+		}
+		heartbeatPeerCount.Set(float64(len(b.peerInventory)))
+		b.heartbeatMx.Unlock()
+
+		heartbeatsReceived.WithLabelValues(hb.Version).Inc()
+
+		return nil
+	}
+}
+
+// GetPeerInventory returns a snapshot of every peer observed via the
+// heartbeat channel, keyed by peer ID.
+func (b *BlossomSub) GetPeerInventory() map[peer.ID]*PeerInventoryEntry {
+	b.heartbeatMx.Lock()
+	defer b.heartbeatMx.Unlock()
+
+	out := make(map[peer.ID]*PeerInventoryEntry, len(b.peerInventory))
+	for id, entry := range b.peerInventory {
+		out[id] = entry
+	}
+
+	return out
+}
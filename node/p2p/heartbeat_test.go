@@ -0,0 +1,229 @@
+package p2p
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/crypto"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"source.quilibrium.com/quilibrium/monorepo/go-libp2p-blossomsub/pb"
+)
+
+// testIdentity is a signing key plus the peer ID it resolves to, used to
+// sign and address test heartbeats.
+type testIdentity struct {
+	priv crypto.PrivKey
+	id   peer.ID
+}
+
+func newTestEd25519Identity(t *testing.T) testIdentity {
+	t.Helper()
+
+	priv, pub, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed25519 key: %v", err)
+	}
+
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatalf("peer id from public key: %v", err)
+	}
+
+	return testIdentity{priv: priv, id: id}
+}
+
+// newTestEd448Identity mirrors newTestEd25519Identity but with this
+// network's actual identity key type. Ed448 public keys are too large for
+// libp2p to inline into the peer ID, so from.ExtractPublicKey() can never
+// recover them the way it can for the Ed25519 keys above - the only real
+// source is a peerstore that recorded the key off the connection.
+func newTestEd448Identity(t *testing.T) testIdentity {
+	t.Helper()
+
+	priv, pub, err := crypto.GenerateEd448Key(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate ed448 key: %v", err)
+	}
+
+	id, err := peer.IDFromPublicKey(pub)
+	if err != nil {
+		t.Fatalf("peer id from public key: %v", err)
+	}
+
+	return testIdentity{priv: priv, id: id}
+}
+
+// newTestBlossomSub builds a BlossomSub whose pubKeyLookup resolves exactly
+// the given identities, standing in for what a connected host's peerstore
+// would hold.
+func newTestBlossomSub(identities ...testIdentity) *BlossomSub {
+	keys := make(map[peer.ID]crypto.PubKey, len(identities))
+	for _, ti := range identities {
+		keys[ti.id] = ti.priv.GetPublic()
+	}
+
+	return &BlossomSub{
+		peerInventory: make(map[peer.ID]*PeerInventoryEntry),
+		pubKeyLookup: func(id peer.ID) crypto.PubKey {
+			return keys[id]
+		},
+	}
+}
+
+func newSignedHeartbeatMessage(
+	t *testing.T,
+	priv crypto.PrivKey,
+	from peer.ID,
+	counter uint64,
+	ts time.Time,
+) *pb.Message {
+	t.Helper()
+
+	hb := &Heartbeat{
+		NodeName:  "test-node",
+		Version:   "v0.0.0",
+		Timestamp: ts.UnixMilli(),
+		Counter:   counter,
+		Nonce:     []byte("0123456789abcdef"),
+	}
+
+	payload, err := hb.signingPayload()
+	if err != nil {
+		t.Fatalf("signing payload: %v", err)
+	}
+
+	sig, err := priv.Sign(payload)
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	hb.Signature = sig
+
+	data, err := json.Marshal(hb)
+	if err != nil {
+		t.Fatalf("marshal heartbeat: %v", err)
+	}
+
+	return &pb.Message{From: []byte(from), Data: data}
+}
+
+func TestHandleHeartbeatAcceptsValidHeartbeat(t *testing.T) {
+	identity := newTestEd25519Identity(t)
+	b := newTestBlossomSub(identity)
+	handle := b.handleHeartbeat(defaultHeartbeatWindow)
+
+	msg := newSignedHeartbeatMessage(t, identity.priv, identity.id, 1, time.Now())
+	if err := handle(msg); err != nil {
+		t.Fatalf("expected a valid heartbeat to be accepted, got: %v", err)
+	}
+
+	if _, ok := b.peerInventory[identity.id]; !ok {
+		t.Fatalf("expected peer to be recorded in inventory")
+	}
+}
+
+// TestHandleHeartbeatAcceptsValidEd448Heartbeat covers this network's actual
+// identity key type, which from.ExtractPublicKey() cannot resolve - only the
+// regression this guards against would have been masked by an Ed25519-only
+// test suite.
+func TestHandleHeartbeatAcceptsValidEd448Heartbeat(t *testing.T) {
+	identity := newTestEd448Identity(t)
+	b := newTestBlossomSub(identity)
+	handle := b.handleHeartbeat(defaultHeartbeatWindow)
+
+	msg := newSignedHeartbeatMessage(t, identity.priv, identity.id, 1, time.Now())
+	if err := handle(msg); err != nil {
+		t.Fatalf("expected a valid Ed448-signed heartbeat to be accepted, got: %v", err)
+	}
+
+	if _, ok := b.peerInventory[identity.id]; !ok {
+		t.Fatalf("expected peer to be recorded in inventory")
+	}
+}
+
+func TestHandleHeartbeatRejectsUnknownPeer(t *testing.T) {
+	identity := newTestEd25519Identity(t)
+	b := newTestBlossomSub() // no identities registered
+	handle := b.handleHeartbeat(defaultHeartbeatWindow)
+
+	msg := newSignedHeartbeatMessage(t, identity.priv, identity.id, 1, time.Now())
+	if err := handle(msg); err == nil {
+		t.Fatalf("expected a heartbeat from a peer with no known public key to be rejected")
+	}
+}
+
+func TestHandleHeartbeatRejectsReplayedCounter(t *testing.T) {
+	identity := newTestEd25519Identity(t)
+	b := newTestBlossomSub(identity)
+	handle := b.handleHeartbeat(defaultHeartbeatWindow)
+
+	first := newSignedHeartbeatMessage(t, identity.priv, identity.id, 5, time.Now())
+	if err := handle(first); err != nil {
+		t.Fatalf("expected first heartbeat to be accepted, got: %v", err)
+	}
+
+	replay := newSignedHeartbeatMessage(t, identity.priv, identity.id, 5, time.Now())
+	if err := handle(replay); err == nil {
+		t.Fatalf("expected a repeated counter to be rejected as a replay")
+	}
+
+	older := newSignedHeartbeatMessage(t, identity.priv, identity.id, 3, time.Now())
+	if err := handle(older); err == nil {
+		t.Fatalf("expected a lower counter to be rejected as a replay")
+	}
+}
+
+func TestHandleHeartbeatAcceptsRestartedPeerAfterInventoryExpires(t *testing.T) {
+	identity := newTestEd25519Identity(t)
+	b := newTestBlossomSub(identity)
+	handle := b.handleHeartbeat(defaultHeartbeatWindow)
+
+	first := newSignedHeartbeatMessage(t, identity.priv, identity.id, 9, time.Now())
+	if err := handle(first); err != nil {
+		t.Fatalf("expected first heartbeat to be accepted, got: %v", err)
+	}
+
+	// Simulate the peer restarting: its counter resets to a lower value,
+	// which would be rejected as a replay while the prior entry is still
+	// considered fresh.
+	restarted := newSignedHeartbeatMessage(t, identity.priv, identity.id, 1, time.Now())
+	if err := handle(restarted); err == nil {
+		t.Fatalf("expected restarted peer's lower counter to still be rejected while the prior entry is fresh")
+	}
+
+	// Age the inventory entry past the TTL, as if the restart happened long
+	// enough ago for it to expire.
+	b.peerInventory[identity.id].LastSeen = time.Now().Add(-defaultHeartbeatInventoryTTL - time.Minute)
+
+	if err := handle(restarted); err != nil {
+		t.Fatalf("expected restarted peer to be accepted once its stale inventory entry expired, got: %v", err)
+	}
+}
+
+func TestHandleHeartbeatRejectsBadSignature(t *testing.T) {
+	identity := newTestEd25519Identity(t)
+	other := newTestEd25519Identity(t)
+	b := newTestBlossomSub(identity, other)
+	handle := b.handleHeartbeat(defaultHeartbeatWindow)
+
+	msg := newSignedHeartbeatMessage(t, identity.priv, identity.id, 1, time.Now())
+	// Claim the message came from a different peer than the one whose key
+	// actually signed it.
+	msg.From = []byte(other.id)
+
+	if err := handle(msg); err == nil {
+		t.Fatalf("expected a heartbeat signed by a different peer's key to be rejected")
+	}
+}
+
+func TestHandleHeartbeatRejectsStaleTimestamp(t *testing.T) {
+	identity := newTestEd25519Identity(t)
+	b := newTestBlossomSub(identity)
+	handle := b.handleHeartbeat(defaultHeartbeatWindow)
+
+	msg := newSignedHeartbeatMessage(t, identity.priv, identity.id, 1, time.Now().Add(-2*defaultHeartbeatWindow))
+	if err := handle(msg); err == nil {
+		t.Fatalf("expected a heartbeat older than the acceptance window to be rejected")
+	}
+}
@@ -0,0 +1,230 @@
+package p2p
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+	"go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+var peersBucket = []byte("peers")
+
+const (
+	defaultReconnectMinBackoff = 5 * time.Second
+	defaultReconnectMaxBackoff = 10 * time.Minute
+	defaultUnreachableTTL      = 7 * 24 * time.Hour
+)
+
+// peerRecord is the bbolt-persisted state kept for a single peer: the
+// addresses it was last observed at and its connection history.
+type peerRecord struct {
+	Addrs     []string  `json:"addrs"`
+	Transport string    `json:"transport"`
+	Successes int       `json:"successes"`
+	Failures  int       `json:"failures"`
+	LastSeen  time.Time `json:"last_seen"`
+}
+
+// persistentPeerstore records observed peers to a bbolt database, mirroring
+// the pattern libs5 uses for its own nodesBucket, so a restarted node can
+// reconnect to known-good peers instead of re-walking the DHT from
+// scratch.
+type persistentPeerstore struct {
+	db     *bbolt.DB
+	logger *zap.Logger
+}
+
+func newPersistentPeerstore(
+	path string,
+	logger *zap.Logger,
+) (*persistentPeerstore, error) {
+	db, err := bbolt.Open(path, 0600, &bbolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, errors.Wrap(err, "open persistent peerstore")
+	}
+
+	err = db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(peersBucket)
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "open persistent peerstore")
+	}
+
+	return &persistentPeerstore{db: db, logger: logger}, nil
+}
+
+func (s *persistentPeerstore) recordSuccess(
+	id peer.ID,
+	addrs []multiaddr.Multiaddr,
+) {
+	s.update(id, addrs, true)
+}
+
+func (s *persistentPeerstore) recordFailure(id peer.ID) {
+	s.update(id, nil, false)
+}
+
+func (s *persistentPeerstore) update(
+	id peer.ID,
+	addrs []multiaddr.Multiaddr,
+	success bool,
+) {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(peersBucket)
+
+		var rec peerRecord
+		if raw := b.Get([]byte(id)); raw != nil {
+			if err := json.Unmarshal(raw, &rec); err != nil {
+				return err
+			}
+		}
+
+		if len(addrs) > 0 {
+			rec.Addrs = rec.Addrs[:0]
+			for _, a := range addrs {
+				rec.Addrs = append(rec.Addrs, a.String())
+			}
+			rec.Transport = transportOf(addrs[0])
+		}
+
+		if success {
+			rec.Successes++
+			rec.LastSeen = time.Now()
+		} else {
+			rec.Failures++
+		}
+
+		data, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+
+		return b.Put([]byte(id), data)
+	})
+	if err != nil {
+		s.logger.Warn("failed to update persistent peerstore", zap.Error(err))
+	}
+}
+
+func (s *persistentPeerstore) forget(id peer.ID) {
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(peersBucket).Delete([]byte(id))
+	})
+	if err != nil {
+		s.logger.Warn("failed to forget peer", zap.Error(err))
+	}
+}
+
+// knownPeers returns every peer record that either has at least one
+// successful connection on file or has not yet exceeded ttl since it was
+// last seen.
+func (s *persistentPeerstore) knownPeers(ttl time.Duration) []peer.AddrInfo {
+	var infos []peer.AddrInfo
+
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(peersBucket).ForEach(func(k, v []byte) error {
+			var rec peerRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				return nil
+			}
+
+			if rec.Successes == 0 && ttl != 0 && time.Since(rec.LastSeen) > ttl {
+				return nil
+			}
+
+			id, err := peer.IDFromBytes(k)
+			if err != nil {
+				return nil
+			}
+
+			addrs := make([]multiaddr.Multiaddr, 0, len(rec.Addrs))
+			for _, a := range rec.Addrs {
+				ma, err := multiaddr.NewMultiaddr(a)
+				if err != nil {
+					continue
+				}
+				// Put addresses on the recorded transport first. That
+				// transport is the one of the first address the host's
+				// peerstore listed at the last recorded success, not
+				// necessarily the transport that connection used.
+				if rec.Transport != "" && transportOf(ma) == rec.Transport {
+					addrs = append([]multiaddr.Multiaddr{ma}, addrs...)
+				} else {
+					addrs = append(addrs, ma)
+				}
+			}
+
+			infos = append(infos, peer.AddrInfo{ID: id, Addrs: addrs})
+			return nil
+		})
+	})
+	if err != nil {
+		s.logger.Warn("failed to list known peers", zap.Error(err))
+	}
+
+	return infos
+}
+
+// prune deletes peer records that have been unreachable for longer than
+// ttl.
+func (s *persistentPeerstore) prune(ttl time.Duration) {
+	cutoff := time.Now().Add(-ttl)
+
+	err := s.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(peersBucket)
+		c := b.Cursor()
+
+		var stale [][]byte
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var rec peerRecord
+			if err := json.Unmarshal(v, &rec); err != nil {
+				continue
+			}
+			if rec.LastSeen.Before(cutoff) {
+				stale = append(stale, append([]byte{}, k...))
+			}
+		}
+
+		for _, k := range stale {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		s.logger.Warn("failed to prune stale peers", zap.Error(err))
+	}
+}
+
+func (s *persistentPeerstore) Close() error {
+	return s.db.Close()
+}
+
+// peerstoreNotifiee records every connection the host makes, from whatever
+// path established it (DHT discovery, heartbeat dials, manual Connect),
+// into the persistent peerstore so seedFromPeerstore and the reconnector
+// have real entries to work with after a restart.
+type peerstoreNotifiee struct {
+	ps *persistentPeerstore
+	h  host.Host
+}
+
+func (n *peerstoreNotifiee) Connected(_ network.Network, conn network.Conn) {
+	id := conn.RemotePeer()
+	n.ps.recordSuccess(id, n.h.Peerstore().Addrs(id))
+}
+
+func (n *peerstoreNotifiee) Disconnected(network.Network, network.Conn) {}
+func (n *peerstoreNotifiee) Listen(network.Network, multiaddr.Multiaddr)      {}
+func (n *peerstoreNotifiee) ListenClose(network.Network, multiaddr.Multiaddr) {}
+
+var _ network.Notifiee = (*peerstoreNotifiee)(nil)
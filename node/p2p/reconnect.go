@@ -0,0 +1,154 @@
+package p2p
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"go.uber.org/zap"
+)
+
+// reconnectInterval is how often the reconnector wakes up to retry known
+// peers that are not currently connected.
+const reconnectInterval = 30 * time.Second
+
+// reconnectState tracks the exponential backoff applied to a single peer.
+type reconnectState struct {
+	delay       time.Duration
+	nextAttempt time.Time
+}
+
+// runReconnector seeds connections from the persistent peerstore and then
+// periodically retries known peers that have dropped, applying an
+// exponential backoff per peer and pruning entries that have been
+// unreachable beyond defaultUnreachableTTL.
+func (b *BlossomSub) runReconnector(ctx context.Context, h host.Host) {
+	b.seedFromPeerstore(ctx, h)
+
+	ticker := time.NewTicker(reconnectInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			b.peerstore.prune(defaultUnreachableTTL)
+			b.reconnectKnownPeers(ctx, h)
+		}
+	}
+}
+
+func (b *BlossomSub) seedFromPeerstore(ctx context.Context, h host.Host) {
+	for _, info := range b.peerstore.knownPeers(defaultUnreachableTTL) {
+		if info.ID == h.ID() {
+			continue
+		}
+
+		if err := b.dialTracked(ctx, h, info); err != nil {
+			b.logger.Debug(
+				"failed to seed connection from peerstore",
+				zap.String("peer_id", info.ID.String()),
+				zap.Error(err),
+			)
+			b.peerstore.recordFailure(info.ID)
+			continue
+		}
+
+		b.logger.Info(
+			"seeded connection from persistent peerstore",
+			zap.String("peer_id", info.ID.String()),
+		)
+		b.peerstore.recordSuccess(info.ID, h.Peerstore().Addrs(info.ID))
+	}
+}
+
+func (b *BlossomSub) reconnectKnownPeers(ctx context.Context, h host.Host) {
+	for _, info := range b.peerstore.knownPeers(defaultUnreachableTTL) {
+		if info.ID == h.ID() {
+			continue
+		}
+
+		if h.Network().Connectedness(info.ID) == network.Connected {
+			b.reconnectMx.Lock()
+			delete(b.reconnectDelay, info.ID)
+			b.reconnectMx.Unlock()
+			continue
+		}
+
+		b.reconnectMx.Lock()
+		state, ok := b.reconnectDelay[info.ID]
+		if !ok {
+			state = &reconnectState{delay: defaultReconnectMinBackoff}
+			b.reconnectDelay[info.ID] = state
+		}
+		due := time.Now().After(state.nextAttempt)
+		b.reconnectMx.Unlock()
+
+		if !due {
+			continue
+		}
+
+		err := b.dialTracked(ctx, h, info)
+
+		b.reconnectMx.Lock()
+		if err != nil {
+			state.delay *= 2
+			if state.delay > defaultReconnectMaxBackoff {
+				state.delay = defaultReconnectMaxBackoff
+			}
+		} else {
+			state.delay = defaultReconnectMinBackoff
+		}
+		state.nextAttempt = time.Now().Add(state.delay)
+		b.reconnectMx.Unlock()
+
+		if err != nil {
+			b.logger.Debug(
+				"reconnect attempt failed",
+				zap.String("peer_id", info.ID.String()),
+				zap.Duration("next_backoff", state.delay),
+				zap.Error(err),
+			)
+			b.peerstore.recordFailure(info.ID)
+			continue
+		}
+
+		b.logger.Info("reconnected to known peer", zap.String("peer_id", info.ID.String()))
+		b.peerstore.recordSuccess(info.ID, h.Peerstore().Addrs(info.ID))
+	}
+}
+
+// GetKnownPeers returns every peer recorded in the persistent peerstore. It
+// returns nil if PeerstorePath was not configured.
+func (b *BlossomSub) GetKnownPeers() []peer.AddrInfo {
+	if b.peerstore == nil {
+		return nil
+	}
+
+	return b.peerstore.knownPeers(0)
+}
+
+// ForgetPeer removes a peer from the persistent peerstore and clears any
+// backoff state tracked for it. It is a no-op if PeerstorePath was not
+// configured.
+func (b *BlossomSub) ForgetPeer(id []byte) error {
+	if b.peerstore == nil {
+		return nil
+	}
+
+	pid, err := peer.IDFromBytes(id)
+	if err != nil {
+		return err
+	}
+
+	b.peerstore.forget(pid)
+
+	b.reconnectMx.Lock()
+	delete(b.reconnectDelay, pid)
+	b.reconnectMx.Unlock()
+
+	return nil
+}
@@ -0,0 +1,153 @@
+package p2p
+
+import (
+	"context"
+
+	"github.com/libp2p/go-libp2p"
+	libp2pconfig "github.com/libp2p/go-libp2p/config"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/security/noise"
+	libp2ptls "github.com/libp2p/go-libp2p/p2p/security/tls"
+	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
+	quic "github.com/libp2p/go-libp2p/p2p/transport/quic"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"source.quilibrium.com/quilibrium/monorepo/node/config"
+)
+
+// transportOpts returns the libp2p options needed to opt into QUIC, NAT
+// traversal (hole punching, AutoRelay, the AutoNAT reachability service) and
+// ForceReachability, according to p2pConfig.
+func transportOpts(
+	p2pConfig *config.P2PConfig,
+	logger *zap.Logger,
+) ([]libp2pconfig.Option, error) {
+	var opts []libp2pconfig.Option
+
+	if p2pConfig.EnableQUIC {
+		if p2pConfig.QUICListenMultiaddr == "" {
+			return nil, errors.New(
+				"quic_listen_multiaddr must be set when enable_quic is true",
+			)
+		}
+
+		// libp2p.Transport/Security replace its usual defaults rather than
+		// adding to them, so the existing TCP transport and Noise security
+		// have to be listed explicitly alongside QUIC and TLS - otherwise an
+		// EnableQUIC node loses the ability to dial or accept the TCP/Noise
+		// mesh every other node is still on.
+		opts = append(
+			opts,
+			libp2p.ListenAddrStrings(p2pConfig.QUICListenMultiaddr),
+			libp2p.Transport(tcp.NewTCPTransport),
+			libp2p.Transport(quic.NewTransport),
+			libp2p.Security(noise.ID, noise.New),
+			libp2p.Security(libp2ptls.ID, libp2ptls.New),
+			libp2p.UserAgent(p2pConfig.Version+"+quic"),
+		)
+	}
+
+	if p2pConfig.EnableHolePunch {
+		opts = append(opts, libp2p.EnableHolePunching())
+	}
+
+	if len(p2pConfig.StaticRelays) > 0 {
+		relays := make([]peer.AddrInfo, 0, len(p2pConfig.StaticRelays))
+		for _, addr := range p2pConfig.StaticRelays {
+			info, err := peer.AddrInfoFromString(addr)
+			if err != nil {
+				return nil, errors.Wrap(err, "parse static relay")
+			}
+			relays = append(relays, *info)
+		}
+
+		opts = append(opts, libp2p.EnableAutoRelayWithStaticRelays(relays))
+	}
+
+	// The AutoNAT service, which answers other peers' reachability probes,
+	// only matters to nodes that asked for some
+	// form of NAT traversal or reachability control; turning it on
+	// unconditionally would change behavior for every existing node, not
+	// just the ones opting in.
+	if p2pConfig.EnableHolePunch ||
+		len(p2pConfig.StaticRelays) > 0 ||
+		p2pConfig.ForceReachability != "" {
+		opts = append(opts, libp2p.EnableNATService())
+	}
+
+	switch p2pConfig.ForceReachability {
+	case "public":
+		opts = append(opts, libp2p.ForceReachability(network.ReachabilityPublic))
+	case "private":
+		opts = append(opts, libp2p.ForceReachability(network.ReachabilityPrivate))
+	case "":
+	default:
+		logger.Warn(
+			"unrecognized force_reachability value, ignoring",
+			zap.String("value", p2pConfig.ForceReachability),
+		)
+	}
+
+	return opts, nil
+}
+
+// watchReachability subscribes to the host's reachability events and keeps
+// b's cached verdict up to date for GetReachability.
+func (b *BlossomSub) watchReachability(ctx context.Context, h host.Host) {
+	sub, err := h.EventBus().Subscribe(new(event.EvtLocalReachabilityChanged))
+	if err != nil {
+		b.logger.Warn("failed to subscribe to reachability events", zap.Error(err))
+		return
+	}
+	defer sub.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case evt, ok := <-sub.Out():
+			if !ok {
+				return
+			}
+
+			reachability := evt.(event.EvtLocalReachabilityChanged).Reachability
+
+			b.reachabilityMx.Lock()
+			b.reachability = reachability
+			b.reachabilityMx.Unlock()
+		}
+	}
+}
+
+// GetReachability returns the most recent AutoNAT reachability verdict for
+// this node.
+func (b *BlossomSub) GetReachability() network.Reachability {
+	b.reachabilityMx.Lock()
+	defer b.reachabilityMx.Unlock()
+
+	return b.reachability
+}
+
+// transportOf returns a short label for the transport a multiaddr uses, so
+// it can be recorded alongside a peer in the persistent peerstore.
+func transportOf(a multiaddr.Multiaddr) string {
+	label := "unknown"
+	for _, p := range a.Protocols() {
+		switch p.Code {
+		case multiaddr.P_QUIC_V1, multiaddr.P_QUIC:
+			return "quic"
+		case multiaddr.P_TCP:
+			// WebSocket runs over TCP, so keep looking for a ws/wss
+			// component before settling on "tcp".
+			label = "tcp"
+		case multiaddr.P_WS, multiaddr.P_WSS:
+			return "websocket"
+		}
+	}
+
+	return label
+}
@@ -0,0 +1,52 @@
+package p2p
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"source.quilibrium.com/quilibrium/monorepo/go-libp2p-blossomsub/pb"
+)
+
+// defaultValidationQueueSize bounds how many messages may be buffered for
+// a single bitmask's processing handler before new ones are dropped.
+const defaultValidationQueueSize = 1024
+
+// defaultValidationWorkers is how many goroutines drain a bitmask's
+// validation queue when ProcessingWorkers is unset.
+const defaultValidationWorkers = 4
+
+var validationDropped = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "p2p_validation_dropped_total",
+		Help: "Messages dropped because a bitmask's validation queue was full.",
+	},
+	[]string{"bitmask"},
+)
+
+// startValidationQueue starts a pool of workers draining a bounded queue
+// for bitmask and returns the channel new messages should be pushed onto.
+// Each worker calls handler synchronously, so handler errors on one
+// message never block another worker's progress.
+func (b *BlossomSub) startValidationQueue(
+	bitmask []byte,
+	handler func(message *pb.Message) error,
+) chan<- *pb.Message {
+	queue := make(chan *pb.Message, b.validationQueueSize)
+
+	for i := 0; i < b.processingWorkers; i++ {
+		go func() {
+			for m := range queue {
+				if err := handler(m); err != nil {
+					b.logger.Error(
+						"message handler returned error",
+						zap.Binary("bitmask", bitmask),
+						zap.Error(err),
+					)
+				}
+			}
+		}()
+	}
+
+	return queue
+}